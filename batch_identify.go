@@ -0,0 +1,142 @@
+package libkb
+
+import (
+	"sync"
+	"time"
+)
+
+// BatchIdentify runs Identify concurrently against many users, bounding
+// the number of outstanding identify operations the same way the PGP
+// verifier pool bounds outstanding key checks: a buffered channel acts
+// as a semaphore, and a WaitGroup tracks completion. A failure on one
+// user is recorded in that user's IdentifyRes.Error and never aborts
+// the rest of the batch.
+func BatchIdentify(usernames []string, arg IdentifyArg) map[string]*IdentifyRes {
+	const maxInFlight = 8
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	s := make(chan struct{}, maxInFlight)
+
+	out := make(map[string]*IdentifyRes, len(usernames))
+
+	for _, name := range usernames {
+		wg.Add(1)
+		s <- struct{}{}
+		go func(name string) {
+			defer wg.Done()
+			defer func() { <-s }()
+
+			res := identifyOneForBatch(name, arg)
+
+			mutex.Lock()
+			out[name] = res
+			mutex.Unlock()
+		}(name)
+	}
+
+	wg.Wait()
+	return out
+}
+
+// identifyOneForBatch loads the named user and runs a normal Identify,
+// wrapping any load error in an IdentifyRes so the caller never has to
+// special-case per-user failures.
+func identifyOneForBatch(name string, arg IdentifyArg) (res *IdentifyRes) {
+	u, err := LoadUser(LoadUserArg{Name: name})
+	if err != nil {
+		return &IdentifyRes{Error: err}
+	}
+	return u.Identify(arg)
+}
+
+// IdentifyScheduler tracks, per username, when we last attempted an
+// identify and when that attempt last succeeded. It's meant to sit
+// underneath a long-running daemon that periodically calls Due to find
+// out who needs to be re-identified.
+type IdentifyScheduler struct {
+	mutex sync.Mutex
+	state map[string]*scheduledIdentify
+}
+
+type scheduledIdentify struct {
+	lastRun     time.Time
+	lastSuccess time.Time
+}
+
+// Retry quickly after a failure, but don't bother re-checking a user
+// who identified cleanly for a good while.
+const (
+	identifyRetryInterval   = time.Minute
+	identifyRefreshInterval = 15 * time.Minute
+)
+
+func NewIdentifyScheduler() *IdentifyScheduler {
+	return &IdentifyScheduler{
+		state: make(map[string]*scheduledIdentify),
+	}
+}
+
+// NextRun returns the earliest time the given user should be
+// identified again, given the last time it was attempted and whether
+// that attempt succeeded.
+func (sc *IdentifyScheduler) NextRun(now time.Time, lastRun time.Time, lastOK bool) time.Time {
+	if lastOK {
+		return lastRun.Add(identifyRefreshInterval)
+	}
+	return lastRun.Add(identifyRetryInterval)
+}
+
+// Due returns the usernames that are due for an identify as of now.
+func (sc *IdentifyScheduler) Due(now time.Time) []string {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	var due []string
+	for name, st := range sc.state {
+		lastOK := !st.lastSuccess.IsZero() && st.lastSuccess.Equal(st.lastRun)
+		if sc.NextRun(now, st.lastRun, lastOK).Before(now) || st.lastRun.IsZero() {
+			due = append(due, name)
+		}
+	}
+	return due
+}
+
+// Record should be called after each BatchIdentify (or single Identify)
+// completes, so the scheduler knows when to check the user again. The
+// run is timestamped from res.CachedAt -- the same TTL Identify's own
+// cache check honors -- rather than the caller's idea of "now", so the
+// scheduler and the cache never disagree about when a result was
+// actually produced.
+func (sc *IdentifyScheduler) Record(name string, res *IdentifyRes) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	st, ok := sc.state[name]
+	if !ok {
+		st = &scheduledIdentify{}
+		sc.state[name] = st
+	}
+	st.lastRun = res.CachedAt
+	if res.GetError() == nil {
+		st.lastSuccess = res.CachedAt
+	}
+}
+
+// RunDue asks the scheduler who's due as of now, runs BatchIdentify
+// against exactly that set, and records each result so the next call
+// to Due reflects it. This is the method a long-running daemon should
+// actually call on a timer; Due/Record are exposed separately mostly
+// so their scheduling logic can be tested without a network.
+func (sc *IdentifyScheduler) RunDue(now time.Time, arg IdentifyArg) map[string]*IdentifyRes {
+	due := sc.Due(now)
+	if len(due) == 0 {
+		return nil
+	}
+
+	results := BatchIdentify(due, arg)
+	for name, res := range results {
+		sc.Record(name, res)
+	}
+	return results
+}
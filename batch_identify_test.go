@@ -0,0 +1,59 @@
+package libkb
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIdentifySchedulerNextRun(t *testing.T) {
+	sc := NewIdentifyScheduler()
+	lastRun := time.Unix(1000, 0)
+
+	if got, want := sc.NextRun(time.Time{}, lastRun, true), lastRun.Add(identifyRefreshInterval); !got.Equal(want) {
+		t.Errorf("NextRun(ok=true) = %v, want %v", got, want)
+	}
+	if got, want := sc.NextRun(time.Time{}, lastRun, false), lastRun.Add(identifyRetryInterval); !got.Equal(want) {
+		t.Errorf("NextRun(ok=false) = %v, want %v", got, want)
+	}
+}
+
+func TestIdentifySchedulerDue(t *testing.T) {
+	sc := NewIdentifyScheduler()
+	base := time.Unix(1_000_000, 0)
+
+	// nothing recorded yet: nobody is due
+	if due := sc.Due(base); len(due) != 0 {
+		t.Fatalf("Due on empty scheduler: got %v, want none", due)
+	}
+
+	sc.Record("alice", &IdentifyRes{CachedAt: base})                          // succeeded just now
+	sc.Record("bob", &IdentifyRes{CachedAt: base, Error: errors.New("nope")}) // failed just now
+
+	due := sc.Due(base.Add(30 * time.Second))
+	if len(due) != 0 {
+		t.Errorf("due only 30s after recording, before the 1m retry interval: %v", due)
+	}
+
+	due = sc.Due(base.Add(identifyRetryInterval + time.Second))
+	if containsString(due, "alice") {
+		t.Errorf("alice due only 61s after a successful run: %v", due)
+	}
+	if !containsString(due, "bob") {
+		t.Errorf("bob not due 61s after a failed run (retry interval is 1m): %v", due)
+	}
+
+	due = sc.Due(base.Add(identifyRefreshInterval + time.Second))
+	if !containsString(due, "alice") {
+		t.Errorf("alice not due after the refresh interval elapsed: %v", due)
+	}
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
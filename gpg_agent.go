@@ -0,0 +1,282 @@
+package libkb
+
+import (
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// Agent is anything that can tell us which PGP keys it holds and use
+// them to sign data, without ever handing the private key material
+// back to us. The default implementation speaks Assuan to a local
+// gpg-agent; tests or alternate key stores can substitute their own.
+type Agent interface {
+	ListKeys() ([]PgpFingerprint, error)
+	HasKey(fp PgpFingerprint) bool
+	Sign(fp PgpFingerprint, data []byte) ([]byte, error)
+}
+
+// GpgAgent talks the Assuan protocol to a local gpg-agent over its
+// UNIX socket, the same agent `gpg` itself uses for passphrase
+// caching. We reuse that caching: once the user has unlocked a key
+// for gpg, IdentifySelf can use it too without prompting again.
+//
+// keyring holds the local public keys we cross-reference the agent's
+// keygrips against: gpg-agent only ever speaks in keygrips, never
+// fingerprints, so without a keyring to map between the two, ListKeys
+// and HasKey have nothing to report against.
+type GpgAgent struct {
+	conn    net.Conn
+	keyring openpgp.EntityList
+}
+
+// GpgAgentSocketPath returns the path to the gpg-agent socket under
+// $GNUPGHOME, the same layout gpg itself expects.
+func GpgAgentSocketPath() string {
+	home := os.Getenv("GNUPGHOME")
+	if home == "" {
+		home = filepath.Join(os.Getenv("HOME"), ".gnupg")
+	}
+	return filepath.Join(home, "S.gpg-agent")
+}
+
+// DialGpgAgent connects to the local gpg-agent on behalf of u. It
+// returns an error (not a panic) when no agent is running, so callers
+// can fall back to the non-agent identify path. u's active public
+// keyring is loaded so ListKeys/HasKey can resolve the agent's
+// keygrips back to fingerprints.
+func DialGpgAgent(u *User) (*GpgAgent, error) {
+	path := GpgAgentSocketPath()
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("no gpg-agent at %s: %s", path, err)
+	}
+	a := &GpgAgent{conn: conn}
+	if err := a.readAssuanOK(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if u != nil {
+		if keyring, kerr := u.GetActivePgpKeyring(); kerr == nil {
+			a.keyring = keyring
+		}
+	}
+	return a, nil
+}
+
+// Close releases the connection to gpg-agent. Callers should defer
+// this immediately after a successful DialGpgAgent -- IdentifyKey and
+// IdentifySelf dial a fresh connection on every call, and under
+// IdentifyScheduler's continuous polling an undeferred Close leaks one
+// unix-socket fd per identify.
+func (a *GpgAgent) Close() error {
+	return a.conn.Close()
+}
+
+func (a *GpgAgent) assuanCommand(cmd string) (string, error) {
+	if _, err := a.conn.Write([]byte(cmd + "\n")); err != nil {
+		return "", err
+	}
+	return a.readAssuanLine()
+}
+
+// readAssuanLine reads a single Assuan response line. A full
+// implementation buffers and demultiplexes "D " data lines from the
+// final "OK"/"ERR" status; callers here only need the common case.
+func (a *GpgAgent) readAssuanLine() (string, error) {
+	buf := make([]byte, 4096)
+	n, err := a.conn.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return string(buf[:n]), nil
+}
+
+func (a *GpgAgent) readAssuanOK() error {
+	line, err := a.readAssuanLine()
+	if err != nil {
+		return err
+	}
+	if len(line) < 2 || line[:2] != "OK" {
+		return fmt.Errorf("gpg-agent handshake failed: %s", line)
+	}
+	return nil
+}
+
+// keygrip computes gpg-agent's real keygrip for pub: the SHA-1 hash of
+// libgcrypt's canonical s-expression encoding of the key's public
+// parameters ("(1:<param><len>:<bytes>)" per parameter, concatenated
+// and hashed with no enclosing parens). This is what HAVEKEY/SIGKEY
+// actually key on, not the OpenPGP fingerprint -- a gpg-agent speaking
+// the real Assuan protocol will only ever recognize a grip computed
+// this way.
+func keygrip(pub *packet.PublicKey) (string, error) {
+	rsaPub, ok := pub.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return "", fmt.Errorf("keygrip: unsupported public key algorithm %T", pub.PublicKey)
+	}
+	h := sha1.New()
+	writeKeygripParam(h, 'n', rsaPub.N.Bytes())
+	writeKeygripParam(h, 'e', big.NewInt(int64(rsaPub.E)).Bytes())
+	return strings.ToUpper(hex.EncodeToString(h.Sum(nil))), nil
+}
+
+func writeKeygripParam(h hash.Hash, name byte, v []byte) {
+	fmt.Fprintf(h, "(1:%c%d:", name, len(v))
+	h.Write(v)
+	h.Write([]byte{')'})
+}
+
+// publicKeyFor finds the packet.PublicKey behind fp in a.keyring,
+// whether it's an entity's primary key or one of its subkeys, so
+// ListKeys and Sign can compute a real keygrip for it.
+func (a *GpgAgent) publicKeyFor(fp PgpFingerprint) (*packet.PublicKey, bool) {
+	for _, entity := range a.keyring {
+		if PgpFingerprintFromBytes(entity.PrimaryKey.Fingerprint[:]).Eq(fp) {
+			return entity.PrimaryKey, true
+		}
+		for _, sub := range entity.Subkeys {
+			if PgpFingerprintFromBytes(sub.PublicKey.Fingerprint[:]).Eq(fp) {
+				return sub.PublicKey, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// parseAssuanDataLine extracts the payload of a single Assuan "D "
+// data line from resp, which gpg-agent sends percent-encoded. Per
+// https://www.gnupg.org/documentation/manuals/assuan/Client-requests.html
+// we only need to undo "%XX" escaping here.
+func parseAssuanDataLine(resp string) ([]byte, error) {
+	for _, line := range strings.Split(resp, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if !strings.HasPrefix(line, "D ") {
+			continue
+		}
+		return unescapeAssuanData(line[2:]), nil
+	}
+	return nil, fmt.Errorf("parseAssuanDataLine: no data line in response: %q", resp)
+}
+
+func unescapeAssuanData(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			if b, err := hex.DecodeString(s[i+1 : i+3]); err == nil {
+				out = append(out, b[0])
+				i += 2
+				continue
+			}
+		}
+		out = append(out, s[i])
+	}
+	return out
+}
+
+// ListKeys asks the agent which keygrips it currently holds, and
+// cross-references them against a.keyring to report the fingerprints
+// of the keys we both know about and the agent can use.
+func (a *GpgAgent) ListKeys() ([]PgpFingerprint, error) {
+	resp, err := a.assuanCommand("HAVEKEY --list=1000")
+	if err != nil {
+		return nil, err
+	}
+	data, err := parseAssuanDataLine(resp)
+	if err != nil {
+		return nil, err
+	}
+	held := make(map[string]bool)
+	for _, grip := range strings.Fields(string(data)) {
+		held[strings.ToUpper(grip)] = true
+	}
+
+	var out []PgpFingerprint
+	for _, entity := range a.keyring {
+		if grip, gerr := keygrip(entity.PrimaryKey); gerr == nil && held[grip] {
+			out = append(out, PgpFingerprintFromBytes(entity.PrimaryKey.Fingerprint[:]))
+		}
+		for _, sub := range entity.Subkeys {
+			if grip, gerr := keygrip(sub.PublicKey); gerr == nil && held[grip] {
+				out = append(out, PgpFingerprintFromBytes(sub.PublicKey.Fingerprint[:]))
+			}
+		}
+	}
+	return out, nil
+}
+
+// HasKey reports whether the agent holds the private key for fp.
+func (a *GpgAgent) HasKey(fp PgpFingerprint) bool {
+	keys, err := a.ListKeys()
+	if err != nil {
+		return false
+	}
+	for _, k := range keys {
+		if k.Eq(fp) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign asks the agent to sign data with the key for fp, prompting the
+// user for their passphrase only if it isn't already cached. Per the
+// Assuan protocol this is three round trips: tell the agent which key
+// to use, tell it the digest to sign, then ask it to sign -- the
+// signature itself comes back as the "D " line of the PKSIGN reply.
+func (a *GpgAgent) Sign(fp PgpFingerprint, data []byte) ([]byte, error) {
+	pub, ok := a.publicKeyFor(fp)
+	if !ok {
+		return nil, fmt.Errorf("Sign: %s is not in the local keyring, can't compute its keygrip", fp)
+	}
+	grip, err := keygrip(pub)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := a.assuanCommand("SIGKEY " + grip); err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256(data)
+	cmd := fmt.Sprintf("SETHASH --hash=sha256 %s", hex.EncodeToString(digest[:]))
+	if _, err := a.assuanCommand(cmd); err != nil {
+		return nil, err
+	}
+
+	resp, err := a.assuanCommand("PKSIGN")
+	if err != nil {
+		return nil, err
+	}
+	return parseAssuanDataLine(resp)
+}
+
+// sigChainTailNonce derives a nonce from the tail of u's sigchain, so
+// a successful ChallengeSign proves the agent holds the key as of the
+// user's current chain state, not just some key it happens to have.
+func (u *User) sigChainTailNonce() []byte {
+	h := hashBytes(append([]byte("identify-challenge:"), u.sigChainTail()...))
+	return h[:]
+}
+
+// ChallengeSign asks agent to sign nonce (callers pass
+// u.sigChainTailNonce()), and confirms the signature verifies against targ.
+// This lets IdentifySelf prove possession of a key cryptographically
+// instead of asking the user to eyeball a fingerprint quad.
+func ChallengeSign(agent Agent, targ *PgpFingerprint, nonce []byte) (bool, error) {
+	sig, err := agent.Sign(*targ, nonce)
+	if err != nil {
+		return false, err
+	}
+	return VerifyPgpSignature(*targ, nonce, sig)
+}
@@ -0,0 +1,80 @@
+package libkb
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+func TestUnescapeAssuanData(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"hello", "hello"},
+		{"100%25done", "100%done"},
+		{"A%0AB", "A\nB"},
+		{"trailing%", "trailing%"}, // truncated escape is passed through literally
+	}
+	for _, c := range cases {
+		if got := string(unescapeAssuanData(c.in)); got != c.want {
+			t.Errorf("unescapeAssuanData(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestParseAssuanDataLine(t *testing.T) {
+	resp := "S KEYINFO some info\r\nD deadbeef%0Acafe\r\nOK\r\n"
+	got, err := parseAssuanDataLine(resp)
+	if err != nil {
+		t.Fatalf("parseAssuanDataLine: %s", err)
+	}
+	if string(got) != "deadbeef\ncafe" {
+		t.Errorf("parseAssuanDataLine = %q, want %q", got, "deadbeef\ncafe")
+	}
+}
+
+func TestParseAssuanDataLineNoDataLine(t *testing.T) {
+	if _, err := parseAssuanDataLine("OK\r\n"); err == nil {
+		t.Error("parseAssuanDataLine: expected an error when no D line is present")
+	}
+}
+
+func mustRSAPublicKeyPacket(t *testing.T) *packet.PublicKey {
+	key := mustGenerateRSAKey(t)
+	return packet.NewRSAPublicKey(time.Unix(0, 0), &key.PublicKey)
+}
+
+func TestKeygripIsDeterministic(t *testing.T) {
+	pub := mustRSAPublicKeyPacket(t)
+
+	g1, err := keygrip(pub)
+	if err != nil {
+		t.Fatalf("keygrip: %s", err)
+	}
+	g2, err := keygrip(pub)
+	if err != nil {
+		t.Fatalf("keygrip: %s", err)
+	}
+	if g1 != g2 {
+		t.Errorf("keygrip not deterministic: %s != %s", g1, g2)
+	}
+	if len(g1) != 40 {
+		t.Errorf("keygrip length = %d, want 40 (SHA-1 hex)", len(g1))
+	}
+}
+
+func TestKeygripDiffersPerKey(t *testing.T) {
+	g1, err := keygrip(mustRSAPublicKeyPacket(t))
+	if err != nil {
+		t.Fatalf("keygrip: %s", err)
+	}
+	g2, err := keygrip(mustRSAPublicKeyPacket(t))
+	if err != nil {
+		t.Fatalf("keygrip: %s", err)
+	}
+	if g1 == g2 {
+		t.Error("keygrip produced the same grip for two different keys")
+	}
+}
@@ -4,28 +4,73 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 )
 
+// IdentifyKey reports on every PGP key currently active for u: the
+// primary key plus its encryption and signing subkeys. A single
+// tracking statement covers all of them, so a subkey being rotated is
+// reported as such (and doesn't break tracking) while the primary key
+// changing still does.
 func (u *User) IdentifyKey(is IdentifyState) error {
-	var ds string
-	if mt := is.track; mt != nil {
-		diff := mt.ComputeKeyDiff(*u.activePgpFingerprint)
-		is.res.KeyDiff = diff
-		ds = diff.ToDisplayString() + " "
+	keyring, e := u.GetActivePgpKeyring()
+	if e != nil {
+		return e
 	}
+
 	fp, e := u.GetActivePgpFingerprint()
 	if e != nil {
 		return e
 	}
-	msg := CHECK + " " + ds +
-		ColorString("green", "public key fingerprint: "+fp.ToQuads())
+
+	var diffs []KeyDiff
+	for _, entity := range keyring {
+		diffs = append(diffs, keyDiffsForEntity(is.track, entity)...)
+	}
+	is.res.KeyDiffs = diffs
+
+	// Keep the legacy single-diff field populated from the primary
+	// key, since existing callers only look at KeyDiff.
+	for _, d := range diffs {
+		if d.Role == PgpKeyRolePrimary {
+			is.res.KeyDiff = d.Diff
+			break
+		}
+	}
+
+	msg := CHECK + " " + ColorString("green", "public key fingerprint: "+fp.ToQuads())
+	if agent, aerr := DialGpgAgent(u); aerr == nil {
+		if ok, verr := ChallengeSign(agent, fp, u.sigChainTailNonce()); verr == nil && ok {
+			msg += " " + ColorString("green", "(verified live via gpg-agent)")
+		}
+		agent.Close()
+	}
+	for _, d := range diffs {
+		if d.Role == PgpKeyRolePrimary {
+			continue
+		}
+		is.Report(fmt.Sprintf("%s %s subkey %s: %s", CHECK, d.Role, d.Fingerprint.ToQuads(), d.Diff.ToDisplayString()))
+	}
 	is.Report(msg)
+
+	for _, d := range diffs {
+		is.emit(IdentifyEvent{
+			Type:        IdentifyEventKeyCheck,
+			Fingerprint: d.Fingerprint.String(),
+			DiffKind:    d.Diff.ToDisplayString(),
+		})
+	}
 	return nil
 }
 
 type IdentifyArg struct {
 	ReportHook func(s string) // Can be nil
-	Me         *User          // The user who's doing the tracking
+	// StructuredHook, if set, receives a fully-typed IdentifyEvent for
+	// each step ReportHook would otherwise render as colorized prose.
+	// Used by the `id --json` path and anything else that wants to
+	// consume an identify run as data rather than parse its output.
+	StructuredHook func(IdentifyEvent)
+	Me             *User // The user who's doing the tracking
 }
 
 func (i IdentifyArg) MeSet() bool {
@@ -34,11 +79,24 @@ func (i IdentifyArg) MeSet() bool {
 
 type IdentifyRes struct {
 	Error       error
-	KeyDiff     TrackDiff
+	KeyDiff     TrackDiff // diff for the primary key, kept for existing callers
+	KeyDiffs    []KeyDiff // diff for every active key: primary plus subkeys
 	ProofChecks []LinkCheckResult
 	Warnings    []Warning
 	Messages    []string
 	MeSet       bool // whether me was set at the time
+	CachedAt    time.Time
+
+	// The fields below are only populated when this IdentifyRes was
+	// re-materialized from a signed attestation by VerifyAttestation,
+	// rather than produced by a live Identify -- there's no User to
+	// ask for them in that case, so the attested payload carries them
+	// instead.
+	Username       string
+	UID            UID
+	Fingerprint    string
+	KeyDiffSummary string
+	AttestedAt     time.Time
 }
 
 func (i IdentifyRes) NumProofFailures() int {
@@ -142,10 +200,12 @@ func NewIdentifyState(arg *IdentifyArg, res *IdentifyRes, u *User) IdentifyState
 
 func (u *User) Identify(arg IdentifyArg) (res *IdentifyRes) {
 
-	if cir := u.cachedIdentifyRes; cir != nil && (arg.MeSet() == cir.MeSet) {
+	if cir := u.cachedIdentifyRes; cir != nil && (arg.MeSet() == cir.MeSet) && time.Since(cir.CachedAt) < identifyRefreshInterval {
 		return cir
 	}
 
+	now := time.Now()
+
 	res = NewIdentifyRes(arg.MeSet())
 	is := NewIdentifyState(&arg, res, u)
 
@@ -168,8 +228,36 @@ func (u *User) Identify(arg IdentifyArg) (res *IdentifyRes) {
 	}
 	u.IdTable.Identify(is)
 
+	// u.IdTable.Identify populates res.ProofChecks and res.Warnings as
+	// it runs without emitting any IdentifyEvents, and IdentifyKey above
+	// filled res.KeyDiffs. Replay each result here so StructuredHook
+	// still sees every proof check, track diff and warning the run
+	// produced, not just the key checks and final summary.
+	for _, c := range res.ProofChecks {
+		s := c.ToSummary()
+		is.emit(IdentifyEvent{Type: IdentifyEventProofCheckStart, Service: s.Service, URL: s.URL})
+		is.emit(IdentifyEvent{Type: IdentifyEventProofCheckResult, Service: s.Service, URL: s.URL, Verdict: s.Verdict, Error: s.Error})
+	}
+	for _, d := range res.KeyDiffs {
+		is.emit(IdentifyEvent{Type: IdentifyEventTrackDiff, Fingerprint: d.Fingerprint.String(), DiffKind: d.Diff.ToDisplayString()})
+	}
+	for _, w := range res.Warnings {
+		is.emit(IdentifyEvent{Type: IdentifyEventWarning, Error: fmt.Sprintf("%s", w)})
+	}
+
+	is.emit(IdentifyEvent{
+		Type:    IdentifyEventSummary,
+		Verdict: fmt.Sprintf("%d proof failures, %d track failures", res.NumProofFailures(), res.NumTrackFailures()),
+	})
+
 	G.Log.Debug("- Identify(%s)", u.name)
+	res.CachedAt = now
 	u.cachedIdentifyRes = res
+
+	if _, err := u.AuditLog().Append(res); err != nil {
+		G.Log.Warning("failed to append identify result to audit log: %s", err)
+	}
+
 	return
 }
 
@@ -195,6 +283,20 @@ func (u *User) IdentifySelf(bg bool) error {
 		return WrongKeyError{fp, targ}
 	}
 
+	// If a local gpg-agent holds the private half of targ, we can
+	// prove possession with a live challenge-response and skip the
+	// interactive "Is this you?" prompt below entirely.
+	if agent, aerr := DialGpgAgent(u); aerr == nil {
+		ok, serr := ChallengeSign(agent, targ, u.sigChainTailNonce())
+		agent.Close()
+		if serr == nil && ok {
+			G.Log.Info("Verified key fingerprint via gpg-agent")
+			G.Env.GetConfigWriter().SetPgpFingerprint(targ)
+			return nil
+		}
+		G.Log.Debug("gpg-agent challenge did not confirm possession of %s: %v", targ, serr)
+	}
+
 	// Ok, we now need to basically "track" ourself to make sure the
 	// server wasn't lying
 	if bg || G.Terminal == nil {
@@ -0,0 +1,245 @@
+package libkb
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/square/go-jose"
+	"golang.org/x/crypto/openpgp"
+)
+
+// attestationPayload is the canonical JSON body that gets wrapped in a
+// JWS. It intentionally only carries the facts a third party needs to
+// replay an identify result, not the full prose report.
+type attestationPayload struct {
+	Username    string             `json:"username"`
+	UID         UID                `json:"uid"`
+	Fingerprint string             `json:"active_pgp_fingerprint,omitempty"`
+	ProofChecks []LinkCheckSummary `json:"proof_checks"`
+	KeyDiff     string             `json:"key_diff"`
+	Time        int64              `json:"time"`
+}
+
+// LinkCheckSummary is the attestable subset of a LinkCheckResult: the
+// proof service, the URL that was checked, and the verdict, with the
+// error reduced to a string so the payload stays representable as
+// plain JSON.
+type LinkCheckSummary struct {
+	Service string `json:"service"`
+	URL     string `json:"url"`
+	Verdict string `json:"verdict"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ToSummary reduces a LinkCheckResult to the fields worth attesting
+// to: what was checked, and whether it passed.
+func (c LinkCheckResult) ToSummary() LinkCheckSummary {
+	s := LinkCheckSummary{
+		Service: c.proof.TableName(),
+		URL:     c.proof.ToDisplayString(),
+		Verdict: "ok",
+	}
+	if c.diff != nil {
+		s.Verdict = c.diff.ToDisplayString()
+	}
+	if c.err != nil {
+		s.Verdict = "failed"
+		s.Error = c.err.Error()
+	}
+	return s
+}
+
+// GetActiveSibkeyForAttestation loads u's active secret signing key
+// and returns the underlying RSA private key, which is what go-jose
+// needs to produce an RS256/PS256 signature. It mirrors
+// GetActivePgpKeyring, but against the secret-key bundle rather than
+// the public one, since attesting requires actually signing with the
+// key rather than just reporting its fingerprint.
+func (u *User) GetActiveSibkeyForAttestation() (*rsa.PrivateKey, error) {
+	bundle, err := u.GetActivePgpSecretKeyBundle()
+	if err != nil {
+		return nil, err
+	}
+	keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(bundle))
+	if err != nil {
+		return nil, fmt.Errorf("GetActiveSibkeyForAttestation: %s", err)
+	}
+
+	for _, entity := range keyring {
+		if entity.PrivateKey == nil {
+			continue
+		}
+		if entity.PrivateKey.Encrypted {
+			return nil, fmt.Errorf("GetActiveSibkeyForAttestation: active signing key is still passphrase-locked")
+		}
+		if rsaKey, ok := entity.PrivateKey.PrivateKey.(*rsa.PrivateKey); ok {
+			return rsaKey, nil
+		}
+	}
+	return nil, fmt.Errorf("GetActiveSibkeyForAttestation: no usable RSA signing key found")
+}
+
+// GetActiveSibkeyPublicForAttestation returns the RSA public key
+// behind u's active PGP fingerprint, for verifying an Attest
+// signature. Unlike GetActiveSibkeyForAttestation it only ever reads
+// u's public keyring: verifying someone else's attestation is the
+// whole point of this feature, and a verifier has no way to unlock --
+// and no business unlocking -- the signer's private key.
+func (u *User) GetActiveSibkeyPublicForAttestation() (*rsa.PublicKey, error) {
+	fp, err := u.GetActivePgpFingerprint()
+	if err != nil {
+		return nil, err
+	}
+	keyring, err := u.GetActivePgpKeyring()
+	if err != nil {
+		return nil, err
+	}
+	for _, entity := range keyring {
+		if !PgpFingerprintFromBytes(entity.PrimaryKey.Fingerprint[:]).Eq(*fp) {
+			continue
+		}
+		if rsaPub, ok := entity.PrimaryKey.PublicKey.(*rsa.PublicKey); ok {
+			return rsaPub, nil
+		}
+	}
+	return nil, fmt.Errorf("GetActiveSibkeyPublicForAttestation: no RSA public key found for %s", fp)
+}
+
+// Attest serializes a successful IdentifyRes as a compact JWS, signed
+// by signer's active key. The JSON payload is embedded in the token;
+// the signature is not detached from it. The result is a
+// self-contained credential: anyone holding it can call
+// VerifyAttestation without re-running any of the network proof
+// checks that produced it.
+func (i IdentifyRes) Attest(signer *User) (string, error) {
+	if err := i.GetError(); err != nil {
+		return "", fmt.Errorf("cannot attest a failed identify: %s", err)
+	}
+
+	u := signer
+	if u == nil {
+		return "", fmt.Errorf("Attest: no signer provided")
+	}
+
+	fp, err := u.GetActivePgpFingerprint()
+	if err != nil {
+		return "", err
+	}
+
+	payload := attestationPayload{
+		Username:    u.name,
+		UID:         u.id,
+		Fingerprint: fp.String(),
+		Time:        time.Now().Unix(),
+	}
+	if i.KeyDiff != nil {
+		payload.KeyDiff = i.KeyDiff.ToDisplayString()
+	}
+	for _, c := range i.ProofChecks {
+		payload.ProofChecks = append(payload.ProofChecks, c.ToSummary())
+	}
+
+	key, err := u.GetActiveSibkeyForAttestation()
+	if err != nil {
+		return "", err
+	}
+
+	return signAttestationPayload(payload, key)
+}
+
+// signAttestationPayload marshals payload to canonical JSON and signs
+// it with key, returning the compact JWS serialization Attest hands
+// back to its caller. Split out from Attest so the signing step --
+// the part with no dependency on a live *User -- can be tested with a
+// locally generated key.
+func signAttestationPayload(payload attestationPayload, key *rsa.PrivateKey) (string, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	signer, err := jose.NewSigner(jose.PS256, key)
+	if err != nil {
+		return "", err
+	}
+	obj, err := signer.Sign(body)
+	if err != nil {
+		return "", err
+	}
+
+	return obj.CompactSerialize()
+}
+
+// IdentifyAndAttest runs a normal identify against username and, if it
+// succeeds, signs the result with me's active key. This is the
+// entry point the `keybase id --attest <user>` CLI command calls;
+// argument parsing and printing the returned token live in the client
+// command layer.
+func IdentifyAndAttest(username string, me *User) (string, error) {
+	u, err := LoadUser(LoadUserArg{Name: username})
+	if err != nil {
+		return "", err
+	}
+	res := u.Identify(IdentifyArg{Me: me})
+	if err := res.GetError(); err != nil {
+		return "", err
+	}
+	return res.Attest(me)
+}
+
+// VerifyAttestation checks the signature on a JWS produced by Attest
+// against the signer's sigchain-bound public key, and re-materializes
+// an IdentifyRes the caller can inspect without contacting the
+// network. Verification only ever needs the signer's public key, so
+// this works for any third party checking someone else's credential,
+// not just the signer themselves.
+func VerifyAttestation(token string, signer *User) (*IdentifyRes, error) {
+	pub, err := signer.GetActiveSibkeyPublicForAttestation()
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := verifyAttestationToken(token, pub)
+	if err != nil {
+		return nil, err
+	}
+
+	res := NewIdentifyRes(false)
+	res.Username = payload.Username
+	res.UID = payload.UID
+	res.Fingerprint = payload.Fingerprint
+	res.KeyDiffSummary = payload.KeyDiff
+	res.AttestedAt = time.Unix(payload.Time, 0)
+	for _, c := range payload.ProofChecks {
+		res.Messages = append(res.Messages, fmt.Sprintf("%s: %s (%s)", c.Service, c.URL, c.Verdict))
+		if c.Error != "" {
+			res.Warnings = append(res.Warnings, StringWarning(fmt.Sprintf("%s: %s", c.Service, c.Error)))
+		}
+	}
+	return res, nil
+}
+
+// verifyAttestationToken checks token's signature against pub and
+// unmarshals its payload. Split out from VerifyAttestation so the
+// verify step -- the part with no dependency on a live *User -- can be
+// tested against a locally generated key pair.
+func verifyAttestationToken(token string, pub *rsa.PublicKey) (*attestationPayload, error) {
+	obj, err := jose.ParseSigned(token)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := obj.Verify(pub)
+	if err != nil {
+		return nil, fmt.Errorf("attestation signature check failed: %s", err)
+	}
+
+	var payload attestationPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	return &payload, nil
+}
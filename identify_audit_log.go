@@ -0,0 +1,251 @@
+package libkb
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Hash is a SHA256 digest, used both for the audit log's hash chain
+// and for its Merkle inclusion proofs.
+type Hash [sha256.Size]byte
+
+func hashBytes(b []byte) Hash {
+	return Hash(sha256.Sum256(b))
+}
+
+// auditLogEntry is one append-only record: an identify result plus
+// the hash of everything that came before it, so tampering with any
+// past entry changes every hash after it.
+type auditLogEntry struct {
+	Seq  int64        `json:"seq"`
+	Prev Hash         `json:"prev"`
+	Res  *IdentifyRes `json:"res"`
+}
+
+// canonicalJSON encodes e with its result's MarshalJSON encoding plus
+// MeSet, CachedAt and Messages, which that encoding leaves out.
+func (e *auditLogEntry) canonicalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Seq      int64        `json:"seq"`
+		Prev     Hash         `json:"prev"`
+		Res      *IdentifyRes `json:"res"`
+		MeSet    bool         `json:"me_set"`
+		CachedAt time.Time    `json:"cached_at"`
+		Messages []string     `json:"messages"`
+	}{e.Seq, e.Prev, e.Res, e.Res.MeSet, e.Res.CachedAt, e.Res.Messages})
+}
+
+func (e *auditLogEntry) hash() (Hash, error) {
+	b, err := e.canonicalJSON()
+	if err != nil {
+		return Hash{}, err
+	}
+	return hashBytes(b), nil
+}
+
+// IdentifyAuditLog is a local, append-only, in-memory record of the
+// identify results produced for a user during the lifetime of this
+// process, chained by hash so that a tamper to any past entry is
+// detectable from every hash after it. Root/InclusionProof/
+// VerifyInclusion are the Merkle-tree primitives a real publish path
+// would sit on top of, not a complete one: nothing here persists
+// entries across a restart or publishes a root anywhere (e.g. via
+// sigchain signing), so today this only protects against tampering an
+// auditor can observe within a single process's lifetime. Wiring in
+// on-disk persistence and a sigchain publish step is still open work.
+//
+// Identify appends a result only when its run gets through the key and
+// proof checks; a run that returns early with an error, and a result
+// served from the user's identify cache, add no entry.
+type IdentifyAuditLog struct {
+	mutex   sync.Mutex
+	entries []*auditLogEntry
+	head    Hash
+}
+
+func NewIdentifyAuditLog() *IdentifyAuditLog {
+	return &IdentifyAuditLog{}
+}
+
+// auditLogMutex guards lazily creating each User's audit log the
+// first time it's needed, since User itself has no constructor in
+// this package to do that wiring up front.
+var auditLogMutex sync.Mutex
+
+// AuditLog returns u's identify audit log, creating it on first use.
+// Identify calls this (rather than checking u.auditLog directly) so
+// every user gets one the first time they're identified, with no
+// separate construction step required.
+func (u *User) AuditLog() *IdentifyAuditLog {
+	auditLogMutex.Lock()
+	defer auditLogMutex.Unlock()
+	if u.auditLog == nil {
+		u.auditLog = NewIdentifyAuditLog()
+	}
+	return u.auditLog
+}
+
+// Append records res as the next entry in the log and returns its
+// sequence number.
+func (l *IdentifyAuditLog) Append(res *IdentifyRes) (int64, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	entry := &auditLogEntry{
+		Seq:  int64(len(l.entries)),
+		Prev: l.head,
+		Res:  res,
+	}
+	h, err := entry.hash()
+	if err != nil {
+		return 0, err
+	}
+
+	l.entries = append(l.entries, entry)
+	l.head = h
+	return entry.Seq, nil
+}
+
+// Head returns the hash of the most recently appended entry, and the
+// total number of entries in the log.
+func (l *IdentifyAuditLog) Head() (Hash, int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.head, int64(len(l.entries))
+}
+
+// merkleLeaf is the hash committed to by a Merkle tree built over a
+// range of audit log entries: the hash of the entry's sequence
+// number and result, domain separated from interior nodes. The result
+// is committed as its MarshalJSON encoding plus MeSet, CachedAt and
+// Messages, which that encoding leaves out. The hash
+// chain (Prev) is deliberately left out here -- it proves ordering
+// within the log itself, while the Merkle tree only needs to commit
+// to the set of (seq, result) pairs an auditor can ask about.
+func merkleLeaf(seq int64, res *IdentifyRes) (Hash, error) {
+	b, err := json.Marshal(struct {
+		Seq      int64        `json:"seq"`
+		Res      *IdentifyRes `json:"res"`
+		MeSet    bool         `json:"me_set"`
+		CachedAt time.Time    `json:"cached_at"`
+		Messages []string     `json:"messages"`
+	}{seq, res, res.MeSet, res.CachedAt, res.Messages})
+	if err != nil {
+		return Hash{}, err
+	}
+	return hashBytes(append([]byte{0x00}, b...)), nil
+}
+
+func merkleParent(left, right Hash) Hash {
+	buf := make([]byte, 0, 1+2*sha256.Size)
+	buf = append(buf, 0x01)
+	buf = append(buf, left[:]...)
+	buf = append(buf, right[:]...)
+	return hashBytes(buf)
+}
+
+// Root computes the Merkle root over every entry currently in the log
+// (recomputed from scratch each call -- there's no incremental tree).
+// A node left without a sibling at any level is paired with itself.
+// Nothing publishes this anywhere yet; see IdentifyAuditLog's doc
+// comment.
+func (l *IdentifyAuditLog) Root() (Hash, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if len(l.entries) == 0 {
+		return Hash{}, nil
+	}
+
+	level := make([]Hash, len(l.entries))
+	for i, e := range l.entries {
+		h, err := merkleLeaf(e.Seq, e.Res)
+		if err != nil {
+			return Hash{}, err
+		}
+		level[i] = h
+	}
+	for len(level) > 1 {
+		var next []Hash
+		for i := 0; i < len(level); i += 2 {
+			if i+1 < len(level) {
+				next = append(next, merkleParent(level[i], level[i+1]))
+			} else {
+				next = append(next, merkleParent(level[i], level[i]))
+			}
+		}
+		level = next
+	}
+	return level[0], nil
+}
+
+// InclusionProof returns the sibling hashes an auditor needs, in
+// order from the leaf up, to recompute the Merkle root for seq and
+// confirm it matches a previously published root.
+func (l *IdentifyAuditLog) InclusionProof(seq int64) ([]Hash, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if seq < 0 || seq >= int64(len(l.entries)) {
+		return nil, fmt.Errorf("InclusionProof: seq %d out of range", seq)
+	}
+
+	level := make([]Hash, len(l.entries))
+	for i, e := range l.entries {
+		h, err := merkleLeaf(e.Seq, e.Res)
+		if err != nil {
+			return nil, err
+		}
+		level[i] = h
+	}
+
+	var proof []Hash
+	idx := int(seq)
+	for len(level) > 1 {
+		var next []Hash
+		for i := 0; i < len(level); i += 2 {
+			if i+1 < len(level) {
+				if i == idx || i+1 == idx {
+					if i == idx {
+						proof = append(proof, level[i+1])
+					} else {
+						proof = append(proof, level[i])
+					}
+				}
+				next = append(next, merkleParent(level[i], level[i+1]))
+			} else {
+				if i == idx {
+					proof = append(proof, level[i])
+				}
+				next = append(next, merkleParent(level[i], level[i]))
+			}
+		}
+		idx /= 2
+		level = next
+	}
+	return proof, nil
+}
+
+// VerifyInclusion recomputes the Merkle root for entry using proof
+// and checks it against root, the last root an auditor fetched and
+// trusts (for example, one published in the signer's sigchain).
+func VerifyInclusion(entry *IdentifyRes, seq int64, proof []Hash, root Hash) (bool, error) {
+	h, err := merkleLeaf(seq, entry)
+	if err != nil {
+		return false, err
+	}
+
+	idx := seq
+	for _, sib := range proof {
+		if idx%2 == 0 {
+			h = merkleParent(h, sib)
+		} else {
+			h = merkleParent(sib, h)
+		}
+		idx /= 2
+	}
+	return h == root, nil
+}
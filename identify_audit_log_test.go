@@ -0,0 +1,103 @@
+package libkb
+
+import "testing"
+
+func TestAuditLogInclusionProof(t *testing.T) {
+	l := NewIdentifyAuditLog()
+
+	var seqs []int64
+	for i := 0; i < 7; i++ {
+		seq, err := l.Append(&IdentifyRes{MeSet: i%2 == 0})
+		if err != nil {
+			t.Fatalf("Append(%d): %s", i, err)
+		}
+		if seq != int64(i) {
+			t.Fatalf("Append(%d): got seq %d, want %d", i, seq, i)
+		}
+		seqs = append(seqs, seq)
+	}
+
+	root, err := l.Root()
+	if err != nil {
+		t.Fatalf("Root: %s", err)
+	}
+
+	for _, seq := range seqs {
+		proof, err := l.InclusionProof(seq)
+		if err != nil {
+			t.Fatalf("InclusionProof(%d): %s", seq, err)
+		}
+		entry := &IdentifyRes{MeSet: seq%2 == 0}
+		ok, err := VerifyInclusion(entry, seq, proof, root)
+		if err != nil {
+			t.Fatalf("VerifyInclusion(%d): %s", seq, err)
+		}
+		if !ok {
+			t.Errorf("VerifyInclusion(%d): proof did not verify against the published root", seq)
+		}
+	}
+}
+
+func TestAuditLogInclusionProofRejectsTamperedEntry(t *testing.T) {
+	l := NewIdentifyAuditLog()
+	for i := 0; i < 4; i++ {
+		if _, err := l.Append(&IdentifyRes{MeSet: i%2 == 0}); err != nil {
+			t.Fatalf("Append(%d): %s", i, err)
+		}
+	}
+
+	root, err := l.Root()
+	if err != nil {
+		t.Fatalf("Root: %s", err)
+	}
+
+	proof, err := l.InclusionProof(1)
+	if err != nil {
+		t.Fatalf("InclusionProof: %s", err)
+	}
+
+	tampered := &IdentifyRes{MeSet: false} // seq 1 was actually MeSet: true
+	ok, err := VerifyInclusion(tampered, 1, proof, root)
+	if err != nil {
+		t.Fatalf("VerifyInclusion: %s", err)
+	}
+	if ok {
+		t.Error("VerifyInclusion accepted a tampered entry against the original root")
+	}
+}
+
+func TestAuditLogInclusionProofOutOfRange(t *testing.T) {
+	l := NewIdentifyAuditLog()
+	if _, err := l.Append(&IdentifyRes{}); err != nil {
+		t.Fatalf("Append: %s", err)
+	}
+
+	if _, err := l.InclusionProof(5); err == nil {
+		t.Error("InclusionProof(5) on a 1-entry log: expected an out-of-range error, got nil")
+	}
+	if _, err := l.InclusionProof(-1); err == nil {
+		t.Error("InclusionProof(-1): expected an out-of-range error, got nil")
+	}
+}
+
+func TestAuditLogHeadTracksAppends(t *testing.T) {
+	l := NewIdentifyAuditLog()
+
+	if head, n := l.Head(); n != 0 || head != (Hash{}) {
+		t.Fatalf("Head on empty log: got (%v, %d), want (zero hash, 0)", head, n)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := l.Append(&IdentifyRes{MeSet: true}); err != nil {
+			t.Fatalf("Append(%d): %s", i, err)
+		}
+	}
+
+	head, n := l.Head()
+	if n != 3 {
+		t.Fatalf("Head: got n=%d, want 3", n)
+	}
+	if head == (Hash{}) {
+		t.Fatal("Head: zero hash after appends")
+	}
+}
@@ -0,0 +1,137 @@
+package libkb
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// IdentifyEventType tags the variant of an IdentifyEvent so a
+// structured consumer can switch on it without inspecting which
+// optional fields are set.
+type IdentifyEventType int
+
+const (
+	IdentifyEventKeyCheck IdentifyEventType = iota
+	IdentifyEventProofCheckStart
+	IdentifyEventProofCheckResult
+	IdentifyEventTrackDiff
+	IdentifyEventWarning
+	IdentifyEventSummary
+)
+
+func (t IdentifyEventType) String() string {
+	switch t {
+	case IdentifyEventKeyCheck:
+		return "key_check"
+	case IdentifyEventProofCheckStart:
+		return "proof_check_start"
+	case IdentifyEventProofCheckResult:
+		return "proof_check_result"
+	case IdentifyEventTrackDiff:
+		return "track_diff"
+	case IdentifyEventWarning:
+		return "warning"
+	case IdentifyEventSummary:
+		return "summary"
+	default:
+		return "unknown"
+	}
+}
+
+func (t IdentifyEventType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
+
+// IdentifyEvent is a single, fully-typed step of an identify run. It
+// is the structured counterpart to the strings IdentifyState.Report
+// sends to IdentifyArg.ReportHook: the same moments, but as data a
+// script can switch on instead of prose it has to parse.
+type IdentifyEvent struct {
+	Type        IdentifyEventType `json:"type"`
+	Fingerprint string            `json:"fingerprint,omitempty"`
+	Service     string            `json:"service,omitempty"`
+	URL         string            `json:"url,omitempty"`
+	Verdict     string            `json:"verdict,omitempty"`
+	DiffKind    string            `json:"diff_kind,omitempty"`
+	Error       string            `json:"error,omitempty"`
+}
+
+// emit sends ev to arg's StructuredHook, if one is set.
+func (i IdentifyState) emit(ev IdentifyEvent) {
+	if i.arg.StructuredHook != nil {
+		i.arg.StructuredHook(ev)
+	}
+}
+
+// jsonKeyDiff is the JSON shape of a KeyDiff: fingerprint and role as
+// plain strings, diff reduced to its display string the same way
+// IdentifyRes.KeyDiff is.
+type jsonKeyDiff struct {
+	Fingerprint string `json:"fingerprint"`
+	Role        string `json:"role"`
+	Diff        string `json:"diff"`
+}
+
+// jsonIdentifyRes mirrors IdentifyRes but with fields already reduced
+// to the stable, documented shapes external consumers should depend
+// on (KeyDiff as a string enum, ProofChecks as summaries), rather than
+// exposing IdentifyRes's internal representation directly.
+type jsonIdentifyRes struct {
+	Error            string             `json:"error,omitempty"`
+	KeyDiff          string             `json:"key_diff"`
+	KeyDiffs         []jsonKeyDiff      `json:"key_diffs,omitempty"`
+	ProofChecks      []LinkCheckSummary `json:"proof_checks"`
+	Warnings         []string           `json:"warnings,omitempty"`
+	NumProofFailures int                `json:"num_proof_failures"`
+	NumTrackFailures int                `json:"num_track_failures"`
+}
+
+// MarshalJSON emits a stable, documented schema for IdentifyRes,
+// independent of how the struct happens to be laid out internally,
+// so pipelines and editor integrations have something to depend on.
+func (i IdentifyRes) MarshalJSON() ([]byte, error) {
+	out := jsonIdentifyRes{
+		NumProofFailures: i.NumProofFailures(),
+		NumTrackFailures: i.NumTrackFailures(),
+	}
+	if i.KeyDiff != nil {
+		out.KeyDiff = i.KeyDiff.ToDisplayString()
+	}
+	if i.Error != nil {
+		out.Error = i.Error.Error()
+	}
+	for _, c := range i.ProofChecks {
+		out.ProofChecks = append(out.ProofChecks, c.ToSummary())
+	}
+	for _, w := range i.Warnings {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("%s", w))
+	}
+	for _, d := range i.KeyDiffs {
+		out.KeyDiffs = append(out.KeyDiffs, jsonKeyDiff{
+			Fingerprint: d.Fingerprint.String(),
+			Role:        string(d.Role),
+			Diff:        d.Diff.ToDisplayString(),
+		})
+	}
+	return json.Marshal(out)
+}
+
+// IdentifyJSON runs Identify against username, passing each
+// IdentifyEvent from StructuredHook to encode as it arrives and then
+// the final IdentifyRes (which encodes via MarshalJSON). This backs the `id --json` CLI
+// flag; flag parsing lives in the client command layer.
+func IdentifyJSON(username string, me *User, encode func(interface{}) error) error {
+	u, err := LoadUser(LoadUserArg{Name: username})
+	if err != nil {
+		return err
+	}
+
+	res := u.Identify(IdentifyArg{
+		Me: me,
+		StructuredHook: func(ev IdentifyEvent) {
+			encode(ev)
+		},
+	})
+
+	return encode(res)
+}
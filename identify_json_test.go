@@ -0,0 +1,76 @@
+package libkb
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestIdentifyResMarshalJSONIncludesKeyDiffs(t *testing.T) {
+	res := IdentifyRes{
+		KeyDiff: TrackDiffUnchanged{},
+		KeyDiffs: []KeyDiff{
+			{Role: PgpKeyRolePrimary, Diff: TrackDiffUnchanged{}},
+			{Role: PgpKeyRoleSigning, Diff: TrackDiffRotated{}},
+		},
+	}
+
+	b, err := json.Marshal(res)
+	if err != nil {
+		t.Fatalf("Marshal: %s", err)
+	}
+
+	var out jsonIdentifyRes
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatalf("Unmarshal: %s", err)
+	}
+	if out.KeyDiff != "unchanged" {
+		t.Errorf("KeyDiff = %q, want %q", out.KeyDiff, "unchanged")
+	}
+	if len(out.KeyDiffs) != 2 {
+		t.Fatalf("KeyDiffs has %d entries, want 2", len(out.KeyDiffs))
+	}
+	if out.KeyDiffs[0].Role != string(PgpKeyRolePrimary) || out.KeyDiffs[0].Diff != "unchanged" {
+		t.Errorf("KeyDiffs[0] = %+v, want role %q diff %q", out.KeyDiffs[0], PgpKeyRolePrimary, "unchanged")
+	}
+	if out.KeyDiffs[1].Role != string(PgpKeyRoleSigning) {
+		t.Errorf("KeyDiffs[1].Role = %q, want %q", out.KeyDiffs[1].Role, PgpKeyRoleSigning)
+	}
+}
+
+func TestIdentifyResMarshalJSONNilKeyDiff(t *testing.T) {
+	res := IdentifyRes{}
+
+	b, err := json.Marshal(res)
+	if err != nil {
+		t.Fatalf("Marshal with a nil KeyDiff: %s", err)
+	}
+
+	var out jsonIdentifyRes
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatalf("Unmarshal: %s", err)
+	}
+	if out.KeyDiff != "" {
+		t.Errorf("KeyDiff = %q, want empty for a nil diff", out.KeyDiff)
+	}
+	if len(out.KeyDiffs) != 0 {
+		t.Errorf("KeyDiffs = %+v, want none for a nil KeyDiffs slice", out.KeyDiffs)
+	}
+}
+
+func TestIdentifyResMarshalJSONError(t *testing.T) {
+	res := IdentifyRes{Error: errors.New("boom")}
+
+	b, err := json.Marshal(res)
+	if err != nil {
+		t.Fatalf("Marshal: %s", err)
+	}
+
+	var out jsonIdentifyRes
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatalf("Unmarshal: %s", err)
+	}
+	if out.Error != "boom" {
+		t.Errorf("Error = %q, want %q", out.Error, "boom")
+	}
+}
@@ -0,0 +1,196 @@
+package libkb
+
+import (
+	"bytes"
+	"fmt"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// pgpKeyRole distinguishes the parts of a PGP entity IdentifyKey now
+// walks, so a KeyDiff can say which one changed.
+type pgpKeyRole string
+
+const (
+	PgpKeyRolePrimary    pgpKeyRole = "primary"
+	PgpKeyRoleEncryption pgpKeyRole = "encryption-subkey"
+	PgpKeyRoleSigning    pgpKeyRole = "signing-subkey"
+)
+
+// KeyDiff is the per-key counterpart to the single-fingerprint
+// TrackDiff IdentifyKey used to produce: which key (by fingerprint and
+// role) changed, and how.
+type KeyDiff struct {
+	Fingerprint PgpFingerprint
+	Role        pgpKeyRole
+	Diff        TrackDiff
+}
+
+// GetActivePgpKeyring loads every PGP key currently considered active
+// for u -- the primary key plus its encryption and signing subkeys --
+// as an openpgp.EntityList, so callers that need to look at more than
+// the single active fingerprint have one place to get them all.
+func (u *User) GetActivePgpKeyring() (openpgp.EntityList, error) {
+	bundle, err := u.GetActivePgpKeyBundle()
+	if err != nil {
+		return nil, err
+	}
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader([]byte(bundle)))
+	if err != nil {
+		return nil, fmt.Errorf("GetActivePgpKeyring: %s", err)
+	}
+	return keyring, nil
+}
+
+// keyDiffsForEntity walks entity's primary key and subkeys, computing a
+// KeyDiff for each one against the signed tracking statement in
+// track -- never against anything merely observed on a previous
+// identify. Tracking statements now record a fingerprint per subkey
+// role alongside the primary one (see TrackLookup.ComputeSubkeyDiff),
+// so a subkey swapped before the very first identify against a given
+// statement -- after a process restart, or from a different device
+// entirely -- is still caught cryptographically instead of defaulting
+// to "new".
+//
+// Two subkeys sharing the same role (e.g. two signing subkeys) are
+// each diffed independently against the single fingerprint the
+// tracking statement records for that role; TrackLookup doesn't yet
+// disambiguate which one a rotation applies to, so one may come back
+// Unchanged and the other Rotated against the same tracked fingerprint.
+func keyDiffsForEntity(track *TrackLookup, entity *openpgp.Entity) []KeyDiff {
+	var diffs []KeyDiff
+
+	primaryFp := PgpFingerprintFromBytes(entity.PrimaryKey.Fingerprint[:])
+	diffs = append(diffs, KeyDiff{
+		Fingerprint: primaryFp,
+		Role:        PgpKeyRolePrimary,
+		Diff:        computePrimaryKeyDiff(track, primaryFp),
+	})
+
+	seenRoles := map[pgpKeyRole]bool{PgpKeyRolePrimary: true}
+	for _, sub := range entity.Subkeys {
+		fp := PgpFingerprintFromBytes(sub.PublicKey.Fingerprint[:])
+		role := subkeyRole(sub)
+		seenRoles[role] = true
+		diffs = append(diffs, KeyDiff{
+			Fingerprint: fp,
+			Role:        role,
+			Diff:        computeSubkeyDiff(track, role, fp),
+		})
+	}
+
+	// A role the tracking statement records but this entity doesn't
+	// present at all means that subkey was dropped/revoked rather than
+	// rotated.
+	if track != nil {
+		for _, role := range track.TrackedSubkeyRoles() {
+			if role == PgpKeyRolePrimary || seenRoles[role] {
+				continue
+			}
+			diffs = append(diffs, KeyDiff{Role: role, Diff: TrackDiffRevoked{}})
+		}
+	}
+
+	return diffs
+}
+
+// subkeyRole classifies sub as an encryption or signing subkey, based
+// on the key flags in its self-signature. A subkey lacking an explicit
+// signing flag is treated as an encryption subkey, the common case.
+func subkeyRole(sub openpgp.Subkey) pgpKeyRole {
+	if sub.Sig != nil && sub.Sig.FlagSign {
+		return PgpKeyRoleSigning
+	}
+	return PgpKeyRoleEncryption
+}
+
+// TrackDiffNew marks a key that didn't appear in any prior tracking
+// statement at all.
+type TrackDiffNew struct{}
+
+func (t TrackDiffNew) ToDisplayString() string { return "new" }
+func (t TrackDiffNew) BreaksTracking() bool    { return false }
+
+// TrackDiffUnchanged marks a key whose fingerprint matches the one the
+// tracking statement records for its role.
+type TrackDiffUnchanged struct{}
+
+func (t TrackDiffUnchanged) ToDisplayString() string { return "unchanged" }
+func (t TrackDiffUnchanged) BreaksTracking() bool    { return false }
+
+// TrackDiffRotated marks a subkey that replaced a previous subkey in
+// the same role (encryption or signing). Unlike the primary key being
+// swapped outright, a rotated subkey doesn't break tracking on its
+// own -- it's the normal way PGP subkeys get renewed.
+type TrackDiffRotated struct {
+	Old PgpFingerprint
+	New PgpFingerprint
+}
+
+func (t TrackDiffRotated) ToDisplayString() string {
+	return fmt.Sprintf("rotated (%s -> %s)", t.Old.ToQuads(), t.New.ToQuads())
+}
+func (t TrackDiffRotated) BreaksTracking() bool { return false }
+
+// TrackDiffRevoked marks a subkey role the tracking statement records
+// but this user no longer presents at all.
+type TrackDiffRevoked struct{}
+
+func (t TrackDiffRevoked) ToDisplayString() string { return "revoked" }
+func (t TrackDiffRevoked) BreaksTracking() bool    { return true }
+
+// computePrimaryKeyDiff computes the TrackDiff for the primary key,
+// using the real tracking-statement-based comparison: this is the one
+// key old tracking statements actually recorded.
+func computePrimaryKeyDiff(track *TrackLookup, fp PgpFingerprint) TrackDiff {
+	if track == nil {
+		return TrackDiffNew{}
+	}
+	return track.ComputeKeyDiff(fp)
+}
+
+// computeSubkeyDiff compares fp, the currently active key for role,
+// against the fingerprint the signed tracking statement recorded for
+// that role -- the same cryptographic check computePrimaryKeyDiff does
+// for the primary key, just keyed by role so an untracked role can
+// still report New. Because it reads the tracking statement rather
+// than a previous run's in-memory result, it works identically on the
+// very first identify against a statement, after a process restart, or
+// from a different device: there's no "prior run" to have forgotten.
+func computeSubkeyDiff(track *TrackLookup, role pgpKeyRole, fp PgpFingerprint) TrackDiff {
+	if track == nil {
+		return TrackDiffNew{}
+	}
+	return track.ComputeSubkeyDiff(role, fp)
+}
+
+// PrivateKeyToken is a server-wrapped subkey secret: an armored PGP
+// message whose payload, once decrypted against the owner's primary
+// key, yields the passphrase (or raw key material) needed to unlock a
+// subkey. This mirrors the ProtonMail token-unlock flow, so a client
+// that only ever prompts for one passphrase can still use subkeys
+// whose secrets the server stores wrapped.
+type PrivateKeyToken struct {
+	Armored string
+}
+
+// Decrypt unwraps the token against primary, returning the subkey
+// secret it protects.
+func (t PrivateKeyToken) Decrypt(primary *openpgp.Entity) ([]byte, error) {
+	block, err := armor.Decode(bytes.NewReader([]byte(t.Armored)))
+	if err != nil {
+		return nil, fmt.Errorf("PrivateKeyToken.Decrypt: bad armor: %s", err)
+	}
+
+	md, err := openpgp.ReadMessage(block.Body, openpgp.EntityList{primary}, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("PrivateKeyToken.Decrypt: %s", err)
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(md.UnverifiedBody); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,67 @@
+package libkb
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+func TestSubkeyRoleDefaultsToEncryption(t *testing.T) {
+	sub := openpgp.Subkey{}
+	if got := subkeyRole(sub); got != PgpKeyRoleEncryption {
+		t.Errorf("subkeyRole with no signing flag = %q, want %q", got, PgpKeyRoleEncryption)
+	}
+}
+
+func TestSubkeyRoleSigning(t *testing.T) {
+	sub := openpgp.Subkey{Sig: &packet.Signature{FlagSign: true}}
+	if got := subkeyRole(sub); got != PgpKeyRoleSigning {
+		t.Errorf("subkeyRole with FlagSign = %q, want %q", got, PgpKeyRoleSigning)
+	}
+}
+
+// TestSubkeyRoleAmbiguousForSameRoleSubkeys documents a known
+// limitation called out in keyDiffsForEntity's doc comment: two
+// signing subkeys on the same entity both classify to the same role,
+// so keyDiffsForEntity has no way to tell them apart when diffing
+// against the single per-role fingerprint a tracking statement
+// records.
+func TestSubkeyRoleAmbiguousForSameRoleSubkeys(t *testing.T) {
+	first := openpgp.Subkey{Sig: &packet.Signature{FlagSign: true}}
+	second := openpgp.Subkey{Sig: &packet.Signature{FlagSign: true}}
+
+	if subkeyRole(first) != subkeyRole(second) {
+		t.Fatalf("expected both signing subkeys to classify to the same role")
+	}
+}
+
+func TestComputeSubkeyDiffNilTrackIsNew(t *testing.T) {
+	if got := computeSubkeyDiff(nil, PgpKeyRoleEncryption, PgpFingerprint{}); got != (TrackDiffNew{}) {
+		t.Errorf("computeSubkeyDiff(nil track) = %#v, want TrackDiffNew{}", got)
+	}
+}
+
+func TestComputePrimaryKeyDiffNilTrackIsNew(t *testing.T) {
+	if got := computePrimaryKeyDiff(nil, PgpFingerprint{}); got != (TrackDiffNew{}) {
+		t.Errorf("computePrimaryKeyDiff(nil track) = %#v, want TrackDiffNew{}", got)
+	}
+}
+
+func TestTrackDiffBreaksTracking(t *testing.T) {
+	cases := []struct {
+		name   string
+		diff   TrackDiff
+		breaks bool
+	}{
+		{"new", TrackDiffNew{}, false},
+		{"unchanged", TrackDiffUnchanged{}, false},
+		{"rotated", TrackDiffRotated{}, false},
+		{"revoked", TrackDiffRevoked{}, true},
+	}
+	for _, c := range cases {
+		if got := c.diff.BreaksTracking(); got != c.breaks {
+			t.Errorf("%s.BreaksTracking() = %v, want %v", c.name, got, c.breaks)
+		}
+	}
+}